@@ -0,0 +1,944 @@
+// Copyright (c) 2015 Magnus Bäck <magnus@noun.se>
+
+// Package testcase reads and represents test case files that
+// describe a set of input lines to feed into Logstash and the
+// events that are expected to come out the other end.
+package testcase
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/magnusbaeck/logstash-filter-verifier/logstash"
+)
+
+// Format identifies the encoding of a test case file as understood
+// by NewFromReaderWithFormat.
+type Format string
+
+// The test case file formats supported by NewFromReaderWithFormat
+// and auto-detected by NewFromFile based on file extension.
+const (
+	JSON Format = "json"
+	YAML Format = "yaml"
+	TOML Format = "toml"
+)
+
+// TestCase represents the test case format that's deserialized from
+// the JSON, YAML or TOML files that the user supplies.
+type TestCase struct {
+	// File contains the absolute path to the file from which this
+	// test case was read. It's not read from the test case file but
+	// populated by NewFromFile for the sake of error messages.
+	File string `json:"-" yaml:"-" toml:"-"`
+
+	// Codec holds the name of the Logstash codec that should be used
+	// when emitting the input lines into the Logstash process.
+	Codec string `json:"codec,omitempty" yaml:"codec,omitempty" toml:"codec,omitempty"`
+
+	// IgnoredFields contains a list of fields in the events that'll
+	// be ignored when comparing actual and expected events, e.g.
+	// because they're known to change from run to run. The list
+	// always contains "@version" by default since that field is
+	// populated with a random value by Logstash.
+	IgnoredFields []string `json:"ignore,omitempty" yaml:"ignore,omitempty" toml:"ignore,omitempty"`
+
+	// InputLines contains the lines of input that should be fed into
+	// the Logstash process.
+	InputLines []string `json:"input,omitempty" yaml:"input,omitempty" toml:"input,omitempty"`
+
+	// ExpectedEvents contains the events that the input lines are
+	// expected to produce.
+	ExpectedEvents []logstash.Event `json:"expected,omitempty" yaml:"expected,omitempty" toml:"expected,omitempty"`
+
+	// Type holds the name of the Logstash type assigned to the
+	// events produced from InputLines.
+	Type string `json:"type,omitempty" yaml:"type,omitempty" toml:"type,omitempty"`
+
+	// Name identifies this test case for the purpose of Filter. It
+	// defaults to the basename of File and may contain slashes to
+	// describe a hierarchy of subtests within a single file, mirroring
+	// the path syntax accepted by "go test -run".
+	Name string `json:"name,omitempty" yaml:"name,omitempty" toml:"name,omitempty"`
+
+	// SelectName names a registered SelectFunc (optionally of the
+	// form "name:argument") used to filter out actual events before
+	// they're compared against ExpectedEvents.
+	SelectName string `json:"select,omitempty" yaml:"select,omitempty" toml:"select,omitempty"`
+
+	// TransformNames names, in order, the registered TransformFunc
+	// factories (optionally of the form "name:argument") applied to
+	// each actual event before Select and the comparison in Compare.
+	TransformNames []string `json:"transform,omitempty" yaml:"transform,omitempty" toml:"transform,omitempty"`
+
+	// Select is the resolved form of SelectName, populated by New.
+	// A nil Select lets every event through.
+	Select SelectFunc `json:"-" yaml:"-" toml:"-"`
+
+	// Transform is the resolved form of TransformNames, populated by
+	// New and applied in order.
+	Transform []TransformFunc `json:"-" yaml:"-" toml:"-"`
+}
+
+// SelectFunc reports whether an actual event should be kept for
+// comparison against ExpectedEvents. Events for which it returns
+// false are discarded before matching begins.
+type SelectFunc func(logstash.Event) bool
+
+// TransformFunc returns a, possibly modified, copy of event. Transforms
+// run before Select and the matcher-aware comparison in Compare, and
+// are the place to normalize volatile fields (e.g. rounding
+// timestamps or redacting a nested subtree) without losing the
+// ability to assert on the rest of the event.
+type TransformFunc func(logstash.Event) logstash.Event
+
+// ComparisonError is an error type that's used to convey how an
+// actual sequence of events differed from what was expected.
+type ComparisonError struct {
+	ActualCount   int
+	ExpectedCount int
+	Mismatches    []MismatchedEvent
+}
+
+// MismatchedEvent describes how one particular actual event
+// differed from its corresponding expected event.
+type MismatchedEvent struct {
+	Actual   logstash.Event
+	Expected logstash.Event
+	Index    int
+
+	// Diffs holds one FieldDiff per field that didn't match, keyed
+	// by its dotted leaf path when the mismatch lies inside nested
+	// maps, so that callers don't have to re-derive what's
+	// different from the full Actual/Expected events.
+	Diffs []FieldDiff
+}
+
+// DiffKind categorizes how an actual field value failed to satisfy
+// the expected one.
+type DiffKind string
+
+// The kinds of field-level mismatches a FieldDiff can describe.
+const (
+	DiffMissing       DiffKind = "missing"
+	DiffExtra         DiffKind = "extra"
+	DiffValueMismatch DiffKind = "value-mismatch"
+	DiffTypeMismatch  DiffKind = "type-mismatch"
+)
+
+// FieldDiff records a single field that differed between an actual
+// and an expected event.
+type FieldDiff struct {
+	Path     string
+	Actual   interface{} `json:",omitempty"`
+	Expected interface{} `json:",omitempty"`
+	Kind     DiffKind
+}
+
+func (e ComparisonError) Error() string {
+	if e.ActualCount != e.ExpectedCount {
+		return fmt.Sprintf("Expected %d event(s), got %d.", e.ExpectedCount, e.ActualCount)
+	}
+	return fmt.Sprintf("%d out of %d event(s) mismatched.", len(e.Mismatches), e.ActualCount)
+}
+
+// MarshalJSON renders e so that CI systems can consume comparison
+// results programmatically, e.g. to post inline PR comments. The
+// human-readable message is included alongside the raw counts and
+// per-event diffs.
+func (e ComparisonError) MarshalJSON() ([]byte, error) {
+	type jsonComparisonError struct {
+		Message       string            `json:"message"`
+		ActualCount   int               `json:"actual_count"`
+		ExpectedCount int               `json:"expected_count"`
+		Mismatches    []MismatchedEvent `json:"mismatches"`
+	}
+	return json.Marshal(jsonComparisonError{
+		Message:       e.Error(),
+		ActualCount:   e.ActualCount,
+		ExpectedCount: e.ExpectedCount,
+		Mismatches:    e.Mismatches,
+	})
+}
+
+// DiffReport renders e as human-friendly text that lists only the
+// paths that differed, rather than dumping the full actual and
+// expected events.
+func (e ComparisonError) DiffReport() string {
+	if e.ActualCount != e.ExpectedCount {
+		return e.Error()
+	}
+	var b strings.Builder
+	for _, m := range e.Mismatches {
+		fmt.Fprintf(&b, "Event %d:\n", m.Index)
+		for _, d := range m.Diffs {
+			fmt.Fprintf(&b, "  %s (%s): expected=%#v actual=%#v\n", d.Path, d.Kind, d.Expected, d.Actual)
+		}
+	}
+	return b.String()
+}
+
+// matcherFunc tests whether an actual field value satisfies a
+// particular matcher expression.
+type matcherFunc func(arg string, actual interface{}) bool
+
+// matchers maps a matcher prefix (e.g. "regex") to the function that
+// evaluates it. Registering a new matcher here makes it usable in
+// any ExpectedEvents value of the form "prefix:argument".
+var matchers = map[string]matcherFunc{
+	"regex": func(arg string, actual interface{}) bool {
+		s, ok := actual.(string)
+		if !ok {
+			return false
+		}
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return false
+		}
+		return re.MatchString(s)
+	},
+	"glob": func(arg string, actual interface{}) bool {
+		s, ok := actual.(string)
+		if !ok {
+			return false
+		}
+		matched, err := filepath.Match(arg, s)
+		if err != nil {
+			return false
+		}
+		return matched
+	},
+	"type": func(arg string, actual interface{}) bool {
+		switch arg {
+		case "number":
+			_, ok := actual.(float64)
+			return ok
+		case "string":
+			_, ok := actual.(string)
+			return ok
+		case "bool":
+			_, ok := actual.(bool)
+			return ok
+		case "array":
+			_, ok := actual.([]interface{})
+			return ok
+		case "object":
+			_, ok := actual.(map[string]interface{})
+			return ok
+		case "null":
+			return actual == nil
+		default:
+			return false
+		}
+	},
+	"range": func(arg string, actual interface{}) bool {
+		n, ok := actual.(float64)
+		if !ok {
+			return false
+		}
+		bounds := strings.SplitN(arg, "..", 2)
+		if len(bounds) != 2 {
+			return false
+		}
+		lo, err := strconv.ParseFloat(bounds[0], 64)
+		if err != nil {
+			return false
+		}
+		hi, err := strconv.ParseFloat(bounds[1], 64)
+		if err != nil {
+			return false
+		}
+		return n >= lo && n <= hi
+	},
+	"notEmpty": func(arg string, actual interface{}) bool {
+		switch v := actual.(type) {
+		case string:
+			return v != ""
+		case []interface{}:
+			return len(v) != 0
+		case map[string]interface{}:
+			return len(v) != 0
+		case nil:
+			return false
+		default:
+			return true
+		}
+	},
+}
+
+// init registers matchers that don't need access to their argument,
+// such as iso8601 which is a fixed-format check.
+func init() {
+	matchers["iso8601"] = func(_ string, actual interface{}) bool {
+		s, ok := actual.(string)
+		if !ok {
+			return false
+		}
+		_, err := time.Parse(time.RFC3339, s)
+		return err == nil
+	}
+}
+
+// matchersRequiringArg lists the matchers whose behavior is
+// meaningless without a ":argument" (an empty regex matches
+// everything, an empty glob matches only the empty string, an empty
+// type or range is never satisfiable). Expected
+// values naming one of these matchers without a colon are treated as
+// plain literal strings instead, so a genuine field value like
+// "regex" doesn't get silently swallowed as a matcher invocation.
+var matchersRequiringArg = map[string]bool{
+	"regex": true,
+	"glob":  true,
+	"type":  true,
+	"range": true,
+}
+
+// resolveMatcher returns the matcher function and argument encoded
+// in expected if expected is a matcher expression of the form
+// "prefix:argument" or a bare matcher name like "iso8601" or
+// "notEmpty". The second return value is false if expected doesn't
+// look like a matcher expression, in which case it should be
+// compared for equality as usual.
+func resolveMatcher(expected interface{}) (matcherFunc, string, bool) {
+	s, ok := expected.(string)
+	if !ok {
+		return nil, "", false
+	}
+	prefix := s
+	arg := ""
+	hasArg := false
+	if i := strings.Index(s, ":"); i >= 0 {
+		prefix, arg = s[:i], s[i+1:]
+		hasArg = true
+	}
+	if !hasArg && matchersRequiringArg[prefix] {
+		return nil, "", false
+	}
+	m, ok := matchers[prefix]
+	return m, arg, ok
+}
+
+// fieldMatches reports whether an actual field value satisfies an
+// expected value, which may be a matcher expression resolved via
+// resolveMatcher, a nested map (recursed into field by field so that
+// a matcher several levels deep, e.g. on a geoip lookup, is resolved
+// rather than diffed structurally), or otherwise a literal value
+// that's compared for deep equality.
+func fieldMatches(expected, actual interface{}) bool {
+	if m, arg, ok := resolveMatcher(expected); ok {
+		return m(arg, actual)
+	}
+	if expectedMap, ok := expected.(map[string]interface{}); ok {
+		actualMap, ok := actual.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		return mapMatches(expectedMap, actualMap)
+	}
+	return reflect.DeepEqual(expected, actual)
+}
+
+// mapMatches reports whether actual satisfies expected, resolving
+// any matcher expressions present in expected's field values at any
+// depth of nested maps. Arrays are not descended into; they're
+// compared for deep equality.
+func mapMatches(expected, actual map[string]interface{}) bool {
+	if len(expected) != len(actual) {
+		return false
+	}
+	for k, expectedValue := range expected {
+		actualValue, found := actual[k]
+		if !found || !fieldMatches(expectedValue, actualValue) {
+			return false
+		}
+	}
+	return true
+}
+
+// eventMatches reports whether actual satisfies expected, resolving
+// any matcher expressions present in expected's field values, at any
+// depth of nested maps (see mapMatches).
+func eventMatches(expected, actual logstash.Event) bool {
+	return mapMatches(map[string]interface{}(expected), map[string]interface{}(actual))
+}
+
+// diffEvent compares expected and actual field by field and returns
+// one FieldDiff per field that doesn't match.
+func diffEvent(expected, actual logstash.Event) []FieldDiff {
+	diffs := diffFields("", map[string]interface{}(expected), map[string]interface{}(actual))
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Path < diffs[j].Path })
+	return diffs
+}
+
+// diffFields compares expected and actual field by field under
+// prefix and returns one FieldDiff per leaf path that doesn't match.
+// When both sides hold a nested map for the same key, it recurses
+// instead of reporting the whole subtree as a single diff, so that a
+// mismatch several levels deep (as is typical in nested Logstash
+// events) points straight at the differing leaf.
+func diffFields(prefix string, expected, actual map[string]interface{}) []FieldDiff {
+	diffs := []FieldDiff{}
+	for k, expectedValue := range expected {
+		path := joinPath(prefix, k)
+		actualValue, found := actual[k]
+		if !found {
+			diffs = append(diffs, FieldDiff{Path: path, Expected: expectedValue, Kind: DiffMissing})
+			continue
+		}
+		if fieldMatches(expectedValue, actualValue) {
+			continue
+		}
+		expectedMap, expectedIsMap := expectedValue.(map[string]interface{})
+		actualMap, actualIsMap := actualValue.(map[string]interface{})
+		if expectedIsMap && actualIsMap {
+			diffs = append(diffs, diffFields(path, expectedMap, actualMap)...)
+			continue
+		}
+		diffs = append(diffs, FieldDiff{
+			Path:     path,
+			Actual:   actualValue,
+			Expected: expectedValue,
+			Kind:     diffKind(expectedValue, actualValue),
+		})
+	}
+	for k, actualValue := range actual {
+		if _, found := expected[k]; !found {
+			diffs = append(diffs, FieldDiff{Path: joinPath(prefix, k), Actual: actualValue, Kind: DiffExtra})
+		}
+	}
+	return diffs
+}
+
+// joinPath appends key to prefix with a "." separator, or returns key
+// unchanged if prefix is empty.
+func joinPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// diffKind classifies a mismatched field as a type or value mismatch.
+// A matcher expression that failed to match is always reported as a
+// value mismatch since its "type" isn't comparable to the actual
+// value's.
+func diffKind(expected, actual interface{}) DiffKind {
+	if _, _, ok := resolveMatcher(expected); ok {
+		return DiffValueMismatch
+	}
+	if reflect.TypeOf(expected) != reflect.TypeOf(actual) {
+		return DiffTypeMismatch
+	}
+	return DiffValueMismatch
+}
+
+// selectFactories maps a SelectName prefix to a function producing a
+// SelectFunc for a given argument. Registering a factory here makes
+// it usable in a test case's "select" field as "name" or
+// "name:argument".
+var selectFactories = map[string]func(arg string) SelectFunc{
+	"hasField": func(path string) SelectFunc {
+		return func(event logstash.Event) bool {
+			_, found := getByPath(event, strings.Split(path, "."))
+			return found
+		}
+	},
+}
+
+// transformFactories maps a TransformNames entry's prefix to a
+// function producing a TransformFunc for a given argument.
+// Registering a factory here makes it usable in a test case's
+// "transform" field as "name" or "name:argument".
+var transformFactories = map[string]func(arg string) TransformFunc{
+	"redact": func(path string) TransformFunc {
+		fields := strings.Split(path, ".")
+		return func(event logstash.Event) logstash.Event {
+			clone := cloneEvent(event)
+			deleteByPath(clone, fields)
+			return clone
+		}
+	},
+	"roundTimestamp": func(path string) TransformFunc {
+		fields := strings.Split(path, ".")
+		return func(event logstash.Event) logstash.Event {
+			clone := cloneEvent(event)
+			roundTimestampByPath(clone, fields)
+			return clone
+		}
+	},
+}
+
+// resolveSelectFunc looks up the SelectFunc factory named by name,
+// which may be of the form "name:argument".
+func resolveSelectFunc(name string) (SelectFunc, error) {
+	prefix, arg := splitNameArg(name)
+	factory, ok := selectFactories[prefix]
+	if !ok {
+		return nil, fmt.Errorf("unknown select function: %q", name)
+	}
+	return factory(arg), nil
+}
+
+// resolveTransformFunc looks up the TransformFunc factory named by
+// name, which may be of the form "name:argument".
+func resolveTransformFunc(name string) (TransformFunc, error) {
+	prefix, arg := splitNameArg(name)
+	factory, ok := transformFactories[prefix]
+	if !ok {
+		return nil, fmt.Errorf("unknown transform function: %q", name)
+	}
+	return factory(arg), nil
+}
+
+// splitNameArg splits a "name:argument" string into its two parts.
+// If there's no colon the argument is the empty string.
+func splitNameArg(s string) (string, string) {
+	if i := strings.Index(s, ":"); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+// New creates a new TestCase from a reader pointing to a JSON test
+// case definition. Use NewFromReaderWithFormat to read YAML or TOML
+// instead.
+func New(reader io.Reader) (*TestCase, error) {
+	return NewFromReaderWithFormat(reader, JSON)
+}
+
+// NewFromReaderWithFormat creates a new TestCase from a reader,
+// decoding it according to format.
+func NewFromReaderWithFormat(reader io.Reader, format Format) (*TestCase, error) {
+	var tc TestCase
+	switch format {
+	case JSON, "":
+		if err := json.NewDecoder(reader).Decode(&tc); err != nil {
+			return nil, err
+		}
+	case YAML:
+		buf, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(buf, &tc); err != nil {
+			return nil, err
+		}
+	case TOML:
+		if _, err := toml.DecodeReader(reader, &tc); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported test case format: %q", format)
+	}
+
+	if format == YAML || format == TOML {
+		for i, event := range tc.ExpectedEvents {
+			tc.ExpectedEvents[i] = normalizeDecodedEvent(event)
+		}
+	}
+
+	if tc.Codec == "" {
+		tc.Codec = "plain"
+	}
+	tc.IgnoredFields = append([]string{"@version"}, tc.IgnoredFields...)
+
+	if tc.SelectName != "" {
+		f, err := resolveSelectFunc(tc.SelectName)
+		if err != nil {
+			return nil, err
+		}
+		tc.Select = f
+	}
+	for _, name := range tc.TransformNames {
+		f, err := resolveTransformFunc(name)
+		if err != nil {
+			return nil, err
+		}
+		tc.Transform = append(tc.Transform, f)
+	}
+	return &tc, nil
+}
+
+// NewFromFile creates a new TestCase from the file at the given path
+// and populates its File field with the file's absolute path. The
+// file's format (JSON, YAML or TOML) is auto-detected from its
+// extension; .yaml/.yml and .toml are recognized, everything else is
+// treated as JSON.
+func NewFromFile(path string) (*TestCase, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(absPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tc, err := NewFromReaderWithFormat(f, formatFromExtension(absPath))
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", absPath, err)
+	}
+	tc.File = absPath
+	if tc.Name == "" {
+		tc.Name = filepath.Base(absPath)
+	}
+	return tc, nil
+}
+
+// formatFromExtension returns the Format that NewFromFile should use
+// to decode path, based on its extension.
+func formatFromExtension(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return YAML
+	case ".toml":
+		return TOML
+	default:
+		return JSON
+	}
+}
+
+// Compare compares the events produced by Logstash to the events
+// expected by this test case and returns an error (of type
+// ComparisonError) if they don't match. If strictOrder is false
+// events may match out of order; today only in-order comparison is
+// implemented and the parameter is accepted for forward
+// compatibility.
+func (tc *TestCase) Compare(actualEvents []logstash.Event, strictOrder bool) error {
+	actualEvents = tc.applyPipeline(actualEvents)
+	if len(actualEvents) != len(tc.ExpectedEvents) {
+		return ComparisonError{
+			ActualCount:   len(actualEvents),
+			ExpectedCount: len(tc.ExpectedEvents),
+			Mismatches:    []MismatchedEvent{},
+		}
+	}
+
+	mismatches := []MismatchedEvent{}
+	for i, expected := range tc.ExpectedEvents {
+		actual := tc.stripIgnoredFields(actualEvents[i])
+		if !eventMatches(expected, actual) {
+			mismatches = append(mismatches, MismatchedEvent{
+				Actual:   actual,
+				Expected: expected,
+				Index:    i,
+				Diffs:    diffEvent(expected, actual),
+			})
+		}
+	}
+	if len(mismatches) > 0 {
+		return ComparisonError{
+			ActualCount:   len(actualEvents),
+			ExpectedCount: len(tc.ExpectedEvents),
+			Mismatches:    mismatches,
+		}
+	}
+	return nil
+}
+
+// applyPipeline runs tc.Transform over every event, in order, and
+// then drops the events that tc.Select rejects.
+func (tc *TestCase) applyPipeline(events []logstash.Event) []logstash.Event {
+	if len(tc.Transform) == 0 && tc.Select == nil {
+		return events
+	}
+	result := make([]logstash.Event, 0, len(events))
+	for _, event := range events {
+		for _, transform := range tc.Transform {
+			event = transform(event)
+		}
+		if tc.Select != nil && !tc.Select(event) {
+			continue
+		}
+		result = append(result, event)
+	}
+	return result
+}
+
+// cloneEvent returns a deep copy of event so that TransformFunc
+// implementations can mutate nested maps and slices without
+// affecting the caller's copy.
+func cloneEvent(event logstash.Event) logstash.Event {
+	return deepClone(map[string]interface{}(event)).(map[string]interface{})
+}
+
+func deepClone(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		clone := make(map[string]interface{}, len(val))
+		for k, vv := range val {
+			clone[k] = deepClone(vv)
+		}
+		return clone
+	case []interface{}:
+		clone := make([]interface{}, len(val))
+		for i, vv := range val {
+			clone[i] = deepClone(vv)
+		}
+		return clone
+	default:
+		return v
+	}
+}
+
+// normalizeDecodedEvent returns a copy of event with the quirks of
+// the YAML and TOML decoders ironed out so that it compares equal to
+// an actual event decoded from Logstash's JSON output: nested maps
+// keyed by interface{} (as produced by gopkg.in/yaml.v2) become
+// map[string]interface{}, and integer values (produced by both
+// decoders for bare numeric literals) become float64, matching what
+// encoding/json produces for every JSON number.
+func normalizeDecodedEvent(event logstash.Event) logstash.Event {
+	return normalizeDecodedValue(map[string]interface{}(event)).(map[string]interface{})
+}
+
+func normalizeDecodedValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, vv := range val {
+			m[fmt.Sprintf("%v", k)] = normalizeDecodedValue(vv)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, vv := range val {
+			m[k] = normalizeDecodedValue(vv)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(val))
+		for i, vv := range val {
+			s[i] = normalizeDecodedValue(vv)
+		}
+		return s
+	case int:
+		return float64(val)
+	case int32:
+		return float64(val)
+	case int64:
+		return float64(val)
+	default:
+		return v
+	}
+}
+
+// getByPath walks event's nested maps following path and returns the
+// value found there, if any.
+func getByPath(event logstash.Event, path []string) (interface{}, bool) {
+	var cur interface{} = map[string]interface{}(event)
+	for _, p := range path {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		cur, ok = m[p]
+		if !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// deleteByPath removes the key named by the last element of path
+// from the nested map reached by following its preceding elements.
+// It's a no-op if the path doesn't exist.
+func deleteByPath(event logstash.Event, path []string) {
+	m, ok := navigateToParent(event, path)
+	if !ok {
+		return
+	}
+	delete(m, path[len(path)-1])
+}
+
+// roundTimestampByPath truncates the RFC3339 timestamp string found
+// at path down to whole seconds. It's a no-op if the path doesn't
+// exist or doesn't hold a parseable timestamp.
+func roundTimestampByPath(event logstash.Event, path []string) {
+	m, ok := navigateToParent(event, path)
+	if !ok {
+		return
+	}
+	key := path[len(path)-1]
+	s, ok := m[key].(string)
+	if !ok {
+		return
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return
+	}
+	m[key] = t.Truncate(time.Second).Format(time.RFC3339)
+}
+
+// navigateToParent walks event's nested maps following all but the
+// last element of path and returns the map that directly contains
+// the final element.
+func navigateToParent(event logstash.Event, path []string) (map[string]interface{}, bool) {
+	if len(path) == 0 {
+		return nil, false
+	}
+	m := map[string]interface{}(event)
+	for _, p := range path[:len(path)-1] {
+		next, ok := m[p].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		m = next
+	}
+	return m, true
+}
+
+// stripIgnoredFields returns a copy of event with the fields named
+// in tc.IgnoredFields removed. Entries in tc.IgnoredFields may be
+// dotted paths (e.g. "geoip.location.lat") reaching into nested
+// maps, and a path segment of the form "key[*]" descends into every
+// element of the array found at key. A path that doesn't exist in
+// event is a no-op rather than an error.
+func (tc *TestCase) stripIgnoredFields(event logstash.Event) logstash.Event {
+	result := cloneEvent(event)
+	for _, f := range tc.IgnoredFields {
+		deleteIgnoredPath(map[string]interface{}(result), parseIgnoredPath(f))
+	}
+	return result
+}
+
+// ignoredPathSegment is one dot-separated component of an
+// IgnoredFields entry.
+type ignoredPathSegment struct {
+	key      string
+	wildcard bool
+}
+
+// parseIgnoredPath splits a dotted IgnoredFields entry into its
+// segments, recognizing a trailing "[*]" on a segment as a wildcard
+// over the array found at that key.
+func parseIgnoredPath(path string) []ignoredPathSegment {
+	parts := strings.Split(path, ".")
+	segments := make([]ignoredPathSegment, len(parts))
+	for i, p := range parts {
+		if strings.HasSuffix(p, "[*]") {
+			segments[i] = ignoredPathSegment{key: strings.TrimSuffix(p, "[*]"), wildcard: true}
+		} else {
+			segments[i] = ignoredPathSegment{key: p}
+		}
+	}
+	return segments
+}
+
+// deleteIgnoredPath removes the field reached by following segments
+// from node, descending into nested maps and, for wildcard segments,
+// every element of a nested array. Missing intermediate keys are a
+// no-op.
+func deleteIgnoredPath(node interface{}, segments []ignoredPathSegment) {
+	if len(segments) == 0 {
+		return
+	}
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		return
+	}
+	seg := segments[0]
+	val, found := m[seg.key]
+	if !found {
+		return
+	}
+	rest := segments[1:]
+	switch {
+	case seg.wildcard:
+		arr, ok := val.([]interface{})
+		if !ok {
+			return
+		}
+		for _, elem := range arr {
+			deleteIgnoredPath(elem, rest)
+		}
+	case len(rest) == 0:
+		delete(m, seg.key)
+	default:
+		deleteIgnoredPath(val, rest)
+	}
+}
+
+// Filter returns the subset of cases whose Name matches pattern,
+// using the same slash-separated regex syntax as "go test -run":
+// pattern is split on "/" into a sequence of regexes, each anchored
+// and matched against the corresponding "/"-separated component of
+// Name. A pattern with more components than Name has unmatched
+// components compared against the empty string, mirroring how
+// "go test -run" treats a pattern that's deeper than the subtests it
+// selects from. An empty pattern matches everything.
+func Filter(cases []*TestCase, pattern string) ([]*TestCase, error) {
+	if pattern == "" {
+		return cases, nil
+	}
+	matchers, err := compileRunPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*TestCase, 0, len(cases))
+	for _, tc := range cases {
+		if matchesRunPattern(tc.Name, matchers) {
+			result = append(result, tc)
+		}
+	}
+	return result, nil
+}
+
+// compileRunPattern splits pattern on "/" and compiles each part as
+// an anchored regex.
+func compileRunPattern(pattern string) ([]*regexp.Regexp, error) {
+	parts := strings.Split(pattern, "/")
+	matchers := make([]*regexp.Regexp, len(parts))
+	for i, p := range parts {
+		re, err := regexp.Compile("^(?:" + p + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %s", pattern, err)
+		}
+		matchers[i] = re
+	}
+	return matchers, nil
+}
+
+// matchesRunPattern reports whether name, split on "/", satisfies
+// matchers component by component.
+func matchesRunPattern(name string, matchers []*regexp.Regexp) bool {
+	nameParts := strings.Split(name, "/")
+	for i, re := range matchers {
+		var part string
+		if i < len(nameParts) {
+			part = nameParts[i]
+		}
+		if !re.MatchString(part) {
+			return false
+		}
+	}
+	return true
+}
+
+// marshalToFile marshals v as indented JSON and writes it to path,
+// creating any missing parent directories.
+func marshalToFile(v interface{}, path string) error {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(buf, '\n'), 0644)
+}
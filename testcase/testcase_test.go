@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/magnusbaeck/logstash-filter-verifier/logstash"
@@ -45,6 +46,17 @@ func TestNew(t *testing.T) {
 				IgnoredFields: []string{"@version", "foo"},
 			},
 		},
+		// select and transform are kept as their raw names; New
+		// doesn't serialize the resolved funcs.
+		{
+			`{"select": "hasField:a", "transform": ["redact:a.b", "roundTimestamp:@timestamp"]}`,
+			TestCase{
+				Codec:          "plain",
+				IgnoredFields:  []string{"@version"},
+				SelectName:     "hasField:a",
+				TransformNames: []string{"redact:a.b", "roundTimestamp:@timestamp"},
+			},
+		},
 	}
 	for i, c := range cases {
 		tc, err := New(bytes.NewReader([]byte(c.input)))
@@ -60,6 +72,188 @@ func TestNew(t *testing.T) {
 	}
 }
 
+// TestNewUnknownFunction verifies that referencing an unregistered
+// select or transform function is an error rather than a silent
+// no-op.
+func TestNewUnknownFunction(t *testing.T) {
+	inputs := []string{
+		`{"select": "bogus"}`,
+		`{"transform": ["bogus"]}`,
+	}
+	for i, input := range inputs {
+		if _, err := New(bytes.NewReader([]byte(input))); err == nil {
+			t.Errorf("Test %d: %q: expected an error, got none.", i, input)
+		}
+	}
+}
+
+// TestCompareWithRegisteredFunctions drives Compare through a
+// TestCase built by New, so that "select" and "transform" go through
+// resolveSelectFunc/resolveTransformFunc and the real hasField,
+// redact and roundTimestamp factories rather than hand-written
+// closures standing in for them.
+func TestCompareWithRegisteredFunctions(t *testing.T) {
+	input := `{
+		"select": "hasField:user",
+		"transform": ["redact:user.password", "roundTimestamp:@timestamp"],
+		"expected": [
+			{"user": {"name": "alice"}, "@timestamp": "2016-06-06T12:00:01Z"}
+		]
+	}`
+	tc, err := New(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatalf("Unexpected error from New: %s", err)
+	}
+
+	actualEvents := []logstash.Event{
+		// Dropped by the "hasField:user" select before comparison.
+		{
+			"other": "noise",
+		},
+		{
+			"user": map[string]interface{}{
+				"name":     "alice",
+				"password": "hunter2",
+			},
+			"@timestamp": "2016-06-06T12:00:01.456Z",
+		},
+	}
+	if err := tc.Compare(actualEvents, true); err != nil {
+		t.Errorf("Expected a match, got: %s", err)
+	}
+}
+
+// TestNewFromReaderWithFormatYAML mirrors TestNew but decodes YAML
+// instead of JSON.
+func TestNewFromReaderWithFormatYAML(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected TestCase
+	}{
+		{
+			"type: mytype\n",
+			TestCase{
+				Codec:         "plain",
+				IgnoredFields: []string{"@version"},
+				Type:          "mytype",
+			},
+		},
+		{
+			"type: mytype\ncodec: json\nignore:\n  - foo\n",
+			TestCase{
+				Codec:         "json",
+				IgnoredFields: []string{"@version", "foo"},
+				Type:          "mytype",
+			},
+		},
+	}
+	for i, c := range cases {
+		tc, err := NewFromReaderWithFormat(bytes.NewReader([]byte(c.input)), YAML)
+		if err != nil {
+			t.Errorf("Test %d: %q input: %s", i, c.input, err)
+			continue
+		}
+		resultJson := marshalTestCase(t, tc)
+		expectedJson := marshalTestCase(t, &c.expected)
+		if expectedJson != resultJson {
+			t.Errorf("Test %d:\nExpected:\n%s\nGot:\n%s", i, expectedJson, resultJson)
+		}
+	}
+}
+
+// TestNewFromReaderWithFormatTOML mirrors TestNew but decodes TOML
+// instead of JSON.
+func TestNewFromReaderWithFormatTOML(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected TestCase
+	}{
+		{
+			`type = "mytype"`,
+			TestCase{
+				Codec:         "plain",
+				IgnoredFields: []string{"@version"},
+				Type:          "mytype",
+			},
+		},
+		{
+			"type = \"mytype\"\ncodec = \"json\"\nignore = [\"foo\"]\n",
+			TestCase{
+				Codec:         "json",
+				IgnoredFields: []string{"@version", "foo"},
+				Type:          "mytype",
+			},
+		},
+	}
+	for i, c := range cases {
+		tc, err := NewFromReaderWithFormat(bytes.NewReader([]byte(c.input)), TOML)
+		if err != nil {
+			t.Errorf("Test %d: %q input: %s", i, c.input, err)
+			continue
+		}
+		resultJson := marshalTestCase(t, tc)
+		expectedJson := marshalTestCase(t, &c.expected)
+		if expectedJson != resultJson {
+			t.Errorf("Test %d:\nExpected:\n%s\nGot:\n%s", i, expectedJson, resultJson)
+		}
+	}
+}
+
+// TestCompareAfterYAMLAndTOMLDecode verifies that a TestCase decoded
+// from YAML or TOML, whose decoders represent nested objects and
+// numbers differently than encoding/json does, still Compare()s
+// successfully against an actual event shaped the way Logstash's
+// real JSON output would be.
+func TestCompareAfterYAMLAndTOMLDecode(t *testing.T) {
+	actualEvents := []logstash.Event{
+		{
+			"status": float64(200),
+			"geoip": map[string]interface{}{
+				"lat": 1.5,
+				"lon": 2.5,
+			},
+		},
+	}
+
+	yamlInput := "expected:\n  - status: 200\n    geoip:\n      lat: 1.5\n      lon: 2.5\n"
+	tc, err := NewFromReaderWithFormat(bytes.NewReader([]byte(yamlInput)), YAML)
+	if err != nil {
+		t.Fatalf("YAML: unexpected error: %s", err)
+	}
+	if err := tc.Compare(actualEvents, true); err != nil {
+		t.Errorf("YAML: expected a match, got: %s", err)
+	}
+
+	tomlInput := "[[expected]]\nstatus = 200\n[expected.geoip]\nlat = 1.5\nlon = 2.5\n"
+	tc, err = NewFromReaderWithFormat(bytes.NewReader([]byte(tomlInput)), TOML)
+	if err != nil {
+		t.Fatalf("TOML: unexpected error: %s", err)
+	}
+	if err := tc.Compare(actualEvents, true); err != nil {
+		t.Errorf("TOML: expected a match, got: %s", err)
+	}
+}
+
+// TestFormatFromExtension checks that NewFromFile picks the right
+// decoder based on a file's extension.
+func TestFormatFromExtension(t *testing.T) {
+	cases := []struct {
+		path     string
+		expected Format
+	}{
+		{"test.json", JSON},
+		{"test.yaml", YAML},
+		{"test.yml", YAML},
+		{"test.toml", TOML},
+		{"test", JSON},
+	}
+	for i, c := range cases {
+		if actual := formatFromExtension(c.path); actual != c.expected {
+			t.Errorf("Test %d: %q: expected %q, got %q", i, c.path, c.expected, actual)
+		}
+	}
+}
+
 // TestNewFromFile smoketests NewFromFile and makes sure it returns
 // an absolute path even if a relative path was given as input.
 func TestNewFromFile(t *testing.T) {
@@ -88,6 +282,9 @@ func TestNewFromFile(t *testing.T) {
 	if tc.File != fullTestCasePath {
 		t.Fatalf("Expected test case path to be %q, got %q instead.", fullTestCasePath, tc.File)
 	}
+	if tc.Name != "test.json" {
+		t.Fatalf("Expected test case name to default to %q, got %q instead.", "test.json", tc.Name)
+	}
 }
 
 func TestCompare(t *testing.T) {
@@ -192,6 +389,10 @@ func TestCompare(t *testing.T) {
 							"a": "b",
 						},
 						Index: 0,
+						Diffs: []FieldDiff{
+							{Path: "a", Expected: "b", Kind: DiffMissing},
+							{Path: "c", Actual: "d", Kind: DiffExtra},
+						},
 					},
 				},
 			},
@@ -226,6 +427,9 @@ func TestCompare(t *testing.T) {
 							"a": "b",
 						},
 						Index: 0,
+						Diffs: []FieldDiff{
+							{Path: "a", Actual: "B", Expected: "b", Kind: DiffValueMismatch},
+						},
 					},
 				},
 			},
@@ -252,6 +456,318 @@ func TestCompare(t *testing.T) {
 			},
 			nil,
 		},
+		// A dotted path ignores a nested field without requiring
+		// the whole subtree to be stripped.
+		{
+			&TestCase{
+				File:          "/path/to/filename.json",
+				Type:          "test",
+				Codec:         "plain",
+				IgnoredFields: []string{"geoip.location.lat", "no.such.path"},
+				InputLines:    []string{},
+				ExpectedEvents: []logstash.Event{
+					{
+						"geoip": map[string]interface{}{
+							"location": map[string]interface{}{
+								"lon": 4.9,
+							},
+						},
+					},
+				},
+			},
+			[]logstash.Event{
+				{
+					"geoip": map[string]interface{}{
+						"location": map[string]interface{}{
+							"lat": 52.3,
+							"lon": 4.9,
+						},
+					},
+				},
+			},
+			nil,
+		},
+		// A "[*]" segment ignores a field under every element of a
+		// nested array.
+		{
+			&TestCase{
+				File:          "/path/to/filename.json",
+				Type:          "test",
+				Codec:         "plain",
+				IgnoredFields: []string{"entries[*].timestamp"},
+				InputLines:    []string{},
+				ExpectedEvents: []logstash.Event{
+					{
+						"entries": []interface{}{
+							map[string]interface{}{"id": "a"},
+							map[string]interface{}{"id": "b"},
+						},
+					},
+				},
+			},
+			[]logstash.Event{
+				{
+					"entries": []interface{}{
+						map[string]interface{}{"id": "a", "timestamp": "2016-06-06T12:00:00Z"},
+						map[string]interface{}{"id": "b", "timestamp": "2016-06-06T12:00:01Z"},
+					},
+				},
+			},
+			nil,
+		},
+		// Matcher expressions resolve instead of requiring
+		// an exact literal match.
+		{
+			&TestCase{
+				File:       "/path/to/filename.json",
+				Type:       "test",
+				Codec:      "plain",
+				InputLines: []string{},
+				ExpectedEvents: []logstash.Event{
+					{
+						"message": "regex:^ERR.*$",
+						"code":    "range:1..100",
+						"id":      "notEmpty",
+					},
+				},
+			},
+			[]logstash.Event{
+				{
+					"message": "ERROR: disk full",
+					"code":    float64(42),
+					"id":      "abc-123",
+				},
+			},
+			nil,
+		},
+		// A matcher expression that doesn't match is still
+		// reported as a mismatch.
+		{
+			&TestCase{
+				File:       "/path/to/filename.json",
+				Type:       "test",
+				Codec:      "plain",
+				InputLines: []string{},
+				ExpectedEvents: []logstash.Event{
+					{
+						"message": "regex:^ERR.*$",
+					},
+				},
+			},
+			[]logstash.Event{
+				{
+					"message": "all good",
+				},
+			},
+			ComparisonError{
+				ActualCount:   1,
+				ExpectedCount: 1,
+				Mismatches: []MismatchedEvent{
+					{
+						Actual: logstash.Event{
+							"message": "all good",
+						},
+						Expected: logstash.Event{
+							"message": "regex:^ERR.*$",
+						},
+						Index: 0,
+						Diffs: []FieldDiff{
+							{Path: "message", Actual: "all good", Expected: "regex:^ERR.*$", Kind: DiffValueMismatch},
+						},
+					},
+				},
+			},
+		},
+		// Transform normalizes a volatile field before comparison,
+		// and Select drops events that don't belong in the result.
+		{
+			&TestCase{
+				File:       "/path/to/filename.json",
+				Type:       "test",
+				Codec:      "plain",
+				InputLines: []string{},
+				Transform: []TransformFunc{
+					func(event logstash.Event) logstash.Event {
+						clone := logstash.Event{}
+						for k, v := range event {
+							clone[k] = v
+						}
+						clone["@timestamp"] = "normalized"
+						return clone
+					},
+				},
+				Select: func(event logstash.Event) bool {
+					return event["keep"] == true
+				},
+				ExpectedEvents: []logstash.Event{
+					{
+						"@timestamp": "normalized",
+						"keep":       true,
+					},
+				},
+			},
+			[]logstash.Event{
+				{
+					"@timestamp": "2016-06-06T12:00:00Z",
+					"keep":       false,
+				},
+				{
+					"@timestamp": "2016-06-06T12:00:01Z",
+					"keep":       true,
+				},
+			},
+			nil,
+		},
+		// A mismatch nested inside a subtree is reported as a diff
+		// on the leaf path rather than the whole subtree.
+		{
+			&TestCase{
+				File:       "/path/to/filename.json",
+				Type:       "test",
+				Codec:      "plain",
+				InputLines: []string{},
+				ExpectedEvents: []logstash.Event{
+					{
+						"geoip": map[string]interface{}{
+							"location": map[string]interface{}{
+								"lat": 52.3,
+								"lon": 4.9,
+							},
+						},
+					},
+				},
+			},
+			[]logstash.Event{
+				{
+					"geoip": map[string]interface{}{
+						"location": map[string]interface{}{
+							"lat": 52.3,
+							"lon": 5.9,
+						},
+					},
+				},
+			},
+			ComparisonError{
+				ActualCount:   1,
+				ExpectedCount: 1,
+				Mismatches: []MismatchedEvent{
+					{
+						Actual: logstash.Event{
+							"geoip": map[string]interface{}{
+								"location": map[string]interface{}{
+									"lat": 52.3,
+									"lon": 5.9,
+								},
+							},
+						},
+						Expected: logstash.Event{
+							"geoip": map[string]interface{}{
+								"location": map[string]interface{}{
+									"lat": 52.3,
+									"lon": 4.9,
+								},
+							},
+						},
+						Index: 0,
+						Diffs: []FieldDiff{
+							{Path: "geoip.location.lon", Actual: 5.9, Expected: 4.9, Kind: DiffValueMismatch},
+						},
+					},
+				},
+			},
+		},
+		// A matcher expression nested inside a subtree is
+		// resolved in place rather than falling back to a
+		// structural comparison of the whole submap.
+		{
+			&TestCase{
+				File:       "/path/to/filename.json",
+				Type:       "test",
+				Codec:      "plain",
+				InputLines: []string{},
+				ExpectedEvents: []logstash.Event{
+					{
+						"geoip": map[string]interface{}{
+							"location": map[string]interface{}{
+								"lat": "range:1..100",
+								"lon": 4.9,
+							},
+						},
+					},
+				},
+			},
+			[]logstash.Event{
+				{
+					"geoip": map[string]interface{}{
+						"location": map[string]interface{}{
+							"lat": float64(52),
+							"lon": 4.9,
+						},
+					},
+				},
+			},
+			nil,
+		},
+		// A field value that happens to equal a matcher name
+		// requiring an argument (e.g. "regex") but has no
+		// ":argument" is treated as a plain literal, not a
+		// matcher invocation, so it only matches that exact
+		// literal value.
+		{
+			&TestCase{
+				File:       "/path/to/filename.json",
+				Type:       "test",
+				Codec:      "plain",
+				InputLines: []string{},
+				ExpectedEvents: []logstash.Event{
+					{
+						"mode": "regex",
+					},
+				},
+			},
+			[]logstash.Event{
+				{
+					"mode": "regex",
+				},
+			},
+			nil,
+		},
+		{
+			&TestCase{
+				File:       "/path/to/filename.json",
+				Type:       "test",
+				Codec:      "plain",
+				InputLines: []string{},
+				ExpectedEvents: []logstash.Event{
+					{
+						"mode": "regex",
+					},
+				},
+			},
+			[]logstash.Event{
+				{
+					"mode": "anything-at-all",
+				},
+			},
+			ComparisonError{
+				ActualCount:   1,
+				ExpectedCount: 1,
+				Mismatches: []MismatchedEvent{
+					{
+						Actual: logstash.Event{
+							"mode": "anything-at-all",
+						},
+						Expected: logstash.Event{
+							"mode": "regex",
+						},
+						Index: 0,
+						Diffs: []FieldDiff{
+							{Path: "mode", Actual: "anything-at-all", Expected: "regex", Kind: DiffValueMismatch},
+						},
+					},
+				},
+			},
+		},
 	}
 
 	for i, c := range cases {
@@ -270,6 +786,11 @@ func TestCompare(t *testing.T) {
 					if !reflect.DeepEqual(c.result, e) {
 						t.Errorf("Test %d:\nExpected:\n%#v\nGot:\n%#v", i, c.result, e)
 					}
+					for _, m := range e.Mismatches {
+						if len(m.Diffs) == 0 {
+							t.Errorf("Test %d: Mismatches[%d] has no Diffs", i, m.Index)
+						}
+					}
 				default:
 					// Except in the explicitly
 					// handled cases above we just
@@ -282,6 +803,104 @@ func TestCompare(t *testing.T) {
 	}
 }
 
+// TestComparisonErrorMarshalJSON verifies that a ComparisonError
+// serializes to JSON that exposes its message alongside the raw
+// counts and per-event diffs, so that CI systems can consume it
+// without re-deriving the human-readable summary.
+func TestComparisonErrorMarshalJSON(t *testing.T) {
+	err := ComparisonError{
+		ActualCount:   1,
+		ExpectedCount: 1,
+		Mismatches: []MismatchedEvent{
+			{
+				Actual:   logstash.Event{"a": "B"},
+				Expected: logstash.Event{"a": "b"},
+				Index:    0,
+				Diffs: []FieldDiff{
+					{Path: "a", Actual: "B", Expected: "b", Kind: DiffValueMismatch},
+				},
+			},
+		},
+	}
+	buf, marshalErr := json.Marshal(err)
+	if marshalErr != nil {
+		t.Fatalf("Unexpected error marshalling ComparisonError: %s", marshalErr)
+	}
+	var decoded map[string]interface{}
+	if unmarshalErr := json.Unmarshal(buf, &decoded); unmarshalErr != nil {
+		t.Fatalf("Unexpected error unmarshalling result: %s", unmarshalErr)
+	}
+	if decoded["message"] != err.Error() {
+		t.Errorf("Expected message %q, got %q", err.Error(), decoded["message"])
+	}
+	if decoded["actual_count"] != float64(1) || decoded["expected_count"] != float64(1) {
+		t.Errorf("Expected counts to round-trip, got %#v", decoded)
+	}
+}
+
+// TestComparisonErrorDiffReport verifies that DiffReport lists only
+// the differing paths rather than the full events.
+func TestComparisonErrorDiffReport(t *testing.T) {
+	err := ComparisonError{
+		ActualCount:   1,
+		ExpectedCount: 1,
+		Mismatches: []MismatchedEvent{
+			{
+				Actual:   logstash.Event{"a": "B", "unwanted": "noise"},
+				Expected: logstash.Event{"a": "b"},
+				Index:    0,
+				Diffs: []FieldDiff{
+					{Path: "a", Actual: "B", Expected: "b", Kind: DiffValueMismatch},
+				},
+			},
+		},
+	}
+	report := err.DiffReport()
+	if !strings.Contains(report, "Event 0:") || !strings.Contains(report, "a (value-mismatch)") {
+		t.Errorf("Expected report to mention the mismatched path, got:\n%s", report)
+	}
+	if strings.Contains(report, "unwanted") {
+		t.Errorf("Expected report to omit fields that weren't part of the diff, got:\n%s", report)
+	}
+}
+
+// TestFilter exercises the "go test -run"-style matching semantics:
+// anchored regexes, matched component by component against Name
+// split on "/".
+func TestFilter(t *testing.T) {
+	cases := []*TestCase{
+		{Name: "grok_apache_combined.json"},
+		{Name: "grok_apache_common.json"},
+		{Name: "date_parsing.json"},
+		{Name: "Grok/ApacheCombined"},
+		{Name: "Grok/ApacheCommon"},
+	}
+	tests := []struct {
+		pattern  string
+		expected []string
+	}{
+		{"", []string{"grok_apache_combined.json", "grok_apache_common.json", "date_parsing.json", "Grok/ApacheCombined", "Grok/ApacheCommon"}},
+		{"grok.*", []string{"grok_apache_combined.json", "grok_apache_common.json"}},
+		{"Grok/.*Combined.*", []string{"Grok/ApacheCombined"}},
+		{"date_parsing.json", []string{"date_parsing.json"}},
+		{"nonexistent", []string{}},
+	}
+	for i, c := range tests {
+		filtered, err := Filter(cases, c.pattern)
+		if err != nil {
+			t.Errorf("Test %d: %q: unexpected error: %s", i, c.pattern, err)
+			continue
+		}
+		var actual []string
+		for _, tc := range filtered {
+			actual = append(actual, tc.Name)
+		}
+		if !reflect.DeepEqual(actual, append([]string{}, c.expected...)) && !(len(actual) == 0 && len(c.expected) == 0) {
+			t.Errorf("Test %d: %q: expected %v, got %v", i, c.pattern, c.expected, actual)
+		}
+	}
+}
+
 func TestMarshalToFile(t *testing.T) {
 	tempdir, err := ioutil.TempDir("", "")
 	if err != nil {
@@ -319,4 +938,4 @@ func marshalTestCase(t *testing.T, tc *TestCase) string {
 		return ""
 	}
 	return string(resultBuf)
-}
\ No newline at end of file
+}